@@ -0,0 +1,280 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	cacheHitsMeasure   = stats.Int64(pkgName+"/cache_hits", "Count of envelope DEK cache hits.", stats.UnitDimensionless)
+	cacheMissesMeasure = stats.Int64(pkgName+"/cache_misses", "Count of envelope DEK cache misses.", stats.UnitDimensionless)
+
+	// CacheViews are predefined views for the DEK cache's OpenCensus
+	// metrics. Applications using NewCachedKeeper can register these
+	// alongside OpenCensusViews; see the example at
+	// https://godoc.org/go.opencensus.io/stats/view for usage.
+	CacheViews = []*view.View{
+		{
+			Name:        pkgName + "/cache_hits",
+			Measure:     cacheHitsMeasure,
+			Description: "Count of envelope DEK cache hits.",
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        pkgName + "/cache_misses",
+			Measure:     cacheMissesMeasure,
+			Description: "Count of envelope DEK cache misses.",
+			Aggregation: view.Count(),
+		},
+	}
+)
+
+// CacheOptions controls the behavior of the DEK cache created by
+// NewCachedKeeper.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of distinct wrapped DEKs to cache.
+	// When exceeded, the least recently used entry is evicted. A value of
+	// 0 means no limit.
+	MaxEntries int
+
+	// TTL is how long a cached DEK remains valid after being unwrapped. A
+	// value of 0 means entries never expire due to age.
+	TTL time.Duration
+
+	// CleanupInterval is how often expired entries are proactively
+	// evicted. A value of 0 disables the background cleanup goroutine;
+	// expired entries are still rejected (and evicted) on lookup.
+	CleanupInterval time.Duration
+}
+
+// CachedKeeper wraps a Keeper to cache unwrapped data encryption keys (DEKs)
+// used by envelope encryption, keyed by the wrapped-DEK bytes. This avoids a
+// KMS round trip on every DecryptEnvelope call when many messages share a
+// DEK, for example repeated reads of the same object, or a batch of records
+// encrypted with a single DEK. Use NewCachedKeeper to create one.
+type CachedKeeper struct {
+	inner *Keeper
+	cache *dekCache
+}
+
+// NewCachedKeeper returns a CachedKeeper that uses inner to wrap and unwrap
+// DEKs, caching unwrapped DEKs according to opts.
+func NewCachedKeeper(inner *Keeper, opts CacheOptions) *CachedKeeper {
+	return &CachedKeeper{
+		inner: inner,
+		cache: newDEKCache(opts),
+	}
+}
+
+// EncryptEnvelope encrypts plaintext the same way Keeper.EncryptEnvelope
+// does; a fresh DEK is generated for every call, so there is nothing to
+// cache on the encrypt path.
+func (ck *CachedKeeper) EncryptEnvelope(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	return ck.inner.EncryptEnvelope(ctx, plaintext, aad)
+}
+
+// DecryptEnvelope decrypts an envelope produced by EncryptEnvelope. If the
+// envelope's wrapped DEK is already cached, it is reused instead of calling
+// the underlying driver to unwrap it again.
+func (ck *CachedKeeper) DecryptEnvelope(ctx context.Context, envelope, aad []byte) (plaintext []byte, err error) {
+	wrappedDEK, nonceAndSealed, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	dek, hit := ck.cache.get(wrappedDEK)
+	if hit {
+		stats.Record(ctx, cacheHitsMeasure.M(1))
+	} else {
+		stats.Record(ctx, cacheMissesMeasure.M(1))
+		dek, err = ck.inner.Decrypt(ctx, wrappedDEK)
+		if err != nil {
+			return nil, err
+		}
+		ck.cache.put(wrappedDEK, dek)
+	}
+	return openWithDEK(dek, nonceAndSealed, aad)
+}
+
+// Close releases the resources used by ck, including the underlying Keeper
+// and any cached DEKs.
+func (ck *CachedKeeper) Close() error {
+	ck.cache.close()
+	return ck.inner.Close()
+}
+
+// dekCache is an LRU cache of unwrapped DEKs with per-entry TTL, keyed by
+// the wrapped-DEK bytes.
+type dekCache struct {
+	mu              sync.Mutex
+	maxEntries      int
+	ttl             time.Duration
+	ll              *list.List
+	items           map[string]*list.Element
+	closed          bool
+	stopCleanupOnce sync.Once
+	stopCleanup     chan struct{}
+}
+
+type dekCacheEntry struct {
+	key     string
+	dek     []byte
+	expires time.Time
+}
+
+func newDEKCache(opts CacheOptions) *dekCache {
+	c := &dekCache{
+		maxEntries:  opts.MaxEntries,
+		ttl:         opts.TTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		stopCleanup: make(chan struct{}),
+	}
+	if opts.CleanupInterval > 0 {
+		go c.cleanupLoop(opts.CleanupInterval)
+	}
+	return c
+}
+
+// get returns a copy of the cached DEK for wrappedDEK, if present and not
+// expired. It must return a copy, not the cache's own backing slice: a
+// concurrent eviction zeroizes that slice in place (see removeElementLocked),
+// and callers may still be using the DEK they got from get (or just handed
+// to put) after the lock is released.
+func (c *dekCache) get(wrappedDEK []byte) (dek []byte, ok bool) {
+	key := string(wrappedDEK)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+	e, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	entry := e.Value.(*dekCacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.removeElementLocked(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return append([]byte(nil), entry.dek...), true
+}
+
+// put inserts a copy of dek into the cache, keyed by wrappedDEK. It copies
+// rather than taking ownership of dek so that the caller, which typically
+// goes on to use dek itself right after inserting it, isn't affected if the
+// cached copy is later zeroized by a concurrent eviction.
+func (c *dekCache) put(wrappedDEK, dek []byte) {
+	key := string(wrappedDEK)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		// The CachedKeeper has been closed; there's nowhere to put this
+		// DEK, and the caller already has what it needs from inner.Decrypt.
+		return
+	}
+
+	if e, found := c.items[key]; found {
+		c.removeElementLocked(e)
+	}
+
+	entry := &dekCacheEntry{key: key, dek: append([]byte(nil), dek...)}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	e := c.ll.PushFront(entry)
+	c.items[key] = e
+
+	if c.maxEntries > 0 {
+		for c.ll.Len() > c.maxEntries {
+			c.removeElementLocked(c.ll.Back())
+		}
+	}
+}
+
+// removeElementLocked removes e from the cache and zeroizes its DEK. c.mu
+// must be held.
+func (c *dekCache) removeElementLocked(e *list.Element) {
+	entry := e.Value.(*dekCacheEntry)
+	zero(entry.dek)
+	delete(c.items, entry.key)
+	c.ll.Remove(e)
+}
+
+func (c *dekCache) cleanupLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.removeExpired()
+		case <-c.stopCleanup:
+			return
+		}
+	}
+}
+
+func (c *dekCache) removeExpired() {
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if now.After(e.Value.(*dekCacheEntry).expires) {
+			c.removeElementLocked(e)
+		}
+		e = prev
+	}
+}
+
+func (c *dekCache) close() {
+	c.stopCleanupOnce.Do(func() { close(c.stopCleanup) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Mark the cache closed (instead of nil-ing c.items) so that get/put
+	// calls racing with this one see a consistent, still-non-nil map and
+	// simply no-op, rather than risking a write to a nil map.
+	c.closed = true
+	for _, e := range c.items {
+		zero(e.Value.(*dekCacheEntry).dek)
+	}
+	c.items = make(map[string]*list.Element)
+	c.ll.Init()
+}
+
+// zero overwrites b with zeroes in place.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
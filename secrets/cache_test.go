@@ -0,0 +1,163 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachedKeeperRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := newFakeKeeper(1)
+	ck := NewCachedKeeper(inner, CacheOptions{MaxEntries: 10})
+	defer ck.Close()
+
+	plaintext := []byte("a secret message")
+	env, err := ck.EncryptEnvelope(ctx, plaintext, nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+
+	// First call is a miss, second is a hit; both must return the same
+	// plaintext.
+	for i := 0; i < 2; i++ {
+		got, err := ck.DecryptEnvelope(ctx, env, nil)
+		if err != nil {
+			t.Fatalf("DecryptEnvelope (call %d): %v", i, err)
+		}
+		if string(got) != string(plaintext) {
+			t.Fatalf("call %d: got %q, want %q", i, got, plaintext)
+		}
+	}
+}
+
+// TestCachedKeeperConcurrentEvictionDoesNotCorruptInFlightDecrypts
+// reproduces a race where a goroutine that fetched (or just inserted) a DEK
+// from the cache has it zeroized out from under it by a concurrent
+// eviction, before it finishes using it to open the envelope. Run with
+// -race.
+func TestCachedKeeperConcurrentEvictionDoesNotCorruptInFlightDecrypts(t *testing.T) {
+	ctx := context.Background()
+	inner := newFakeKeeper(1)
+	// MaxEntries: 1 forces every distinct envelope inserted by the writer
+	// goroutines to evict the shared envelope's cached DEK.
+	ck := NewCachedKeeper(inner, CacheOptions{MaxEntries: 1})
+	defer ck.Close()
+
+	plaintext := []byte("a shared secret message")
+	sharedEnv, err := ck.EncryptEnvelope(ctx, plaintext, nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+
+	const duration = 300 * time.Millisecond
+	const numReaders = 8
+	const numWriters = 8
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < numReaders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				got, err := ck.DecryptEnvelope(ctx, sharedEnv, nil)
+				if err != nil || string(got) != string(plaintext) {
+					t.Errorf("DecryptEnvelope of untampered shared envelope failed: got (%q, %v)", got, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				env, err := ck.EncryptEnvelope(ctx, []byte(fmt.Sprintf("writer %d payload", i)), nil)
+				if err != nil {
+					t.Errorf("EncryptEnvelope: %v", err)
+					return
+				}
+				if _, err := ck.DecryptEnvelope(ctx, env, nil); err != nil {
+					t.Errorf("DecryptEnvelope of freshly-written envelope failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(duration)
+	close(stop)
+	wg.Wait()
+}
+
+// TestCachedKeeperCloseConcurrentWithDecrypt reproduces a panic where
+// DecryptEnvelope, racing with Close, misses the cache and calls put,
+// writing into a cache whose backing map Close had nil'd out. Run with
+// -race.
+func TestCachedKeeperCloseConcurrentWithDecrypt(t *testing.T) {
+	ctx := context.Background()
+	inner := newFakeKeeper(1)
+	ck := NewCachedKeeper(inner, CacheOptions{MaxEntries: 10})
+
+	plaintext := []byte("a secret message")
+	env, err := ck.EncryptEnvelope(ctx, plaintext, nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Errors are expected once Close has run; the point of
+				// this test is that concurrent use of a closing cache
+				// must not panic.
+				ck.DecryptEnvelope(ctx, env, nil)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := ck.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// contextFrameVersion identifies the layout of the portable framed
+// ciphertext produced by EncryptWithContext when the underlying driver does
+// not support encryption context natively.
+const contextFrameVersion = 1
+
+// macKeySize is the size in bytes of the random key used to HMAC-bind the
+// encryption context and inner ciphertext together in the portable fallback
+// path.
+const macKeySize = 32
+
+// maxContextFrameFieldLen bounds the length of each length-prefixed field
+// (the wrapped MAC key and the canonicalized context) read from an
+// untrusted context frame. Wrapped keys are at most a few KiB and contexts
+// are small maps of strings; anything larger indicates a truncated or
+// malicious frame and must be rejected before allocating a buffer for it.
+const maxContextFrameFieldLen = 4 * 1024
+
+// contextKeeper is implemented by drivers that can bind an encryption
+// context to a ciphertext natively (for example, AWS KMS and GCP KMS accept
+// additional authenticated data, and HashiCorp Vault transit accepts a
+// "context" parameter). Keeper.EncryptWithContext and DecryptWithContext use
+// it when available, and otherwise fall back to a portable HMAC-based
+// framing that works with any driver.
+type contextKeeper interface {
+	EncryptWithContext(ctx context.Context, plaintext []byte, encryptionContext map[string]string) ([]byte, error)
+	DecryptWithContext(ctx context.Context, ciphertext []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// EncryptWithContext encrypts plaintext and binds encryptionContext to the
+// resulting ciphertext, modeled after the "encryption context"/AAD concept
+// in AWS KMS, GCP KMS, and HashiCorp Vault transit. DecryptWithContext must
+// be called with the same encryptionContext or decryption fails; this lets
+// callers tie a ciphertext to metadata such as a tenant ID, object key, or
+// purpose, and have that binding enforced regardless of which provider
+// backs the Keeper.
+//
+// If the underlying driver supports encryption context natively, it is used
+// directly. Otherwise, EncryptWithContext emits a portable ciphertext that
+// carries the canonicalized context alongside a random key (wrapped via the
+// driver, so only this Keeper can recover it) used to HMAC-SHA256 the
+// context and inner ciphertext together.
+func (k *Keeper) EncryptWithContext(ctx context.Context, plaintext []byte, encryptionContext map[string]string) (ciphertext []byte, err error) {
+	ctx = k.tracer.Start(ctx, "EncryptWithContext")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	if ck, ok := k.k.(contextKeeper); ok {
+		b, err := ck.EncryptWithContext(ctx, plaintext, encryptionContext)
+		if err != nil {
+			return nil, wrapError(k, err)
+		}
+		return b, nil
+	}
+
+	ctxBytes, err := canonicalContext(encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := k.k.Encrypt(ctx, plaintext)
+	if err != nil {
+		return nil, wrapError(k, err)
+	}
+	macKey := make([]byte, macKeySize)
+	if _, err := rand.Read(macKey); err != nil {
+		return nil, fmt.Errorf("secrets: generating context MAC key: %v", err)
+	}
+	wrappedMACKey, err := k.k.Encrypt(ctx, macKey)
+	if err != nil {
+		return nil, wrapError(k, err)
+	}
+	tag := contextTag(macKey, ctxBytes, inner)
+
+	frame := make([]byte, 0, 1+4+len(wrappedMACKey)+4+len(ctxBytes)+len(tag)+len(inner))
+	frame = append(frame, contextFrameVersion)
+	frame = appendLenPrefixed(frame, wrappedMACKey)
+	frame = appendLenPrefixed(frame, ctxBytes)
+	frame = append(frame, tag...)
+	frame = append(frame, inner...)
+	return frame, nil
+}
+
+// DecryptWithContext decrypts ciphertext produced by EncryptWithContext.
+// It fails closed if encryptionContext does not match the context the
+// ciphertext was encrypted with.
+func (k *Keeper) DecryptWithContext(ctx context.Context, ciphertext []byte, encryptionContext map[string]string) (plaintext []byte, err error) {
+	ctx = k.tracer.Start(ctx, "DecryptWithContext")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	if ck, ok := k.k.(contextKeeper); ok {
+		b, err := ck.DecryptWithContext(ctx, ciphertext, encryptionContext)
+		if err != nil {
+			return nil, wrapError(k, err)
+		}
+		return b, nil
+	}
+
+	wrappedMACKey, ctxBytes, tag, inner, err := parseContextFrame(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	wantCtxBytes, err := canonicalContext(encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ctxBytes, wantCtxBytes) {
+		return nil, fmt.Errorf("secrets: encryption context does not match")
+	}
+	macKey, err := k.k.Decrypt(ctx, wrappedMACKey)
+	if err != nil {
+		return nil, wrapError(k, err)
+	}
+	if !hmac.Equal(contextTag(macKey, ctxBytes, inner), tag) {
+		return nil, fmt.Errorf("secrets: ciphertext failed authentication")
+	}
+	pt, err := k.k.Decrypt(ctx, inner)
+	if err != nil {
+		return nil, wrapError(k, err)
+	}
+	return pt, nil
+}
+
+// canonicalContext serializes an encryption context as canonical JSON.
+// encoding/json always emits object keys of a map[string]string in sorted
+// order, so this is stable across calls for the same map contents.
+func canonicalContext(encryptionContext map[string]string) ([]byte, error) {
+	b, err := json.Marshal(encryptionContext)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: marshaling encryption context: %v", err)
+	}
+	return b, nil
+}
+
+// contextTag computes the HMAC-SHA256 binding tag over the frame version,
+// canonicalized context, and inner ciphertext.
+func contextTag(macKey, ctxBytes, inner []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte{contextFrameVersion})
+	mac.Write(ctxBytes)
+	mac.Write(inner)
+	return mac.Sum(nil)
+}
+
+// parseContextFrame splits a ciphertext produced by the portable path of
+// EncryptWithContext into its wrapped MAC key, canonicalized context, tag,
+// and inner ciphertext.
+func parseContextFrame(frame []byte) (wrappedMACKey, ctxBytes, tag, inner []byte, err error) {
+	if len(frame) < 1 {
+		return nil, nil, nil, nil, fmt.Errorf("secrets: empty ciphertext")
+	}
+	if v := frame[0]; v != contextFrameVersion {
+		return nil, nil, nil, nil, fmt.Errorf("secrets: unsupported context frame version %d", v)
+	}
+	frame = frame[1:]
+
+	wrappedMACKey, frame, err = takeLenPrefixed(frame)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	ctxBytes, frame, err = takeLenPrefixed(frame)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if len(frame) < sha256.Size {
+		return nil, nil, nil, nil, fmt.Errorf("secrets: truncated ciphertext")
+	}
+	tag, inner = frame[:sha256.Size], frame[sha256.Size:]
+	return wrappedMACKey, ctxBytes, tag, inner, nil
+}
+
+// takeLenPrefixed reads a 4-byte big-endian length prefix followed by that
+// many bytes off the front of b, returning the bytes read and the
+// remainder. It fails closed on an oversized length rather than risk an
+// integer overflow or an oversized allocation: n is decoded as an unsigned
+// uint32 (as parseEnvelope does), and bounded by maxContextFrameFieldLen,
+// before it is ever used as a slice index.
+func takeLenPrefixed(b []byte) (head, rest []byte, err error) {
+	if len(b) < 4 {
+		return nil, nil, fmt.Errorf("secrets: truncated ciphertext")
+	}
+	n := binary.BigEndian.Uint32(b[:4])
+	if n > maxContextFrameFieldLen {
+		return nil, nil, fmt.Errorf("secrets: context frame field length %d exceeds maximum of %d", n, maxContextFrameFieldLen)
+	}
+	b = b[4:]
+	if uint32(len(b)) < n {
+		return nil, nil, fmt.Errorf("secrets: truncated ciphertext")
+	}
+	return b[:n], b[n:], nil
+}
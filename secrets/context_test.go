@@ -0,0 +1,117 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncryptDecryptWithContextRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	plaintext := []byte("a secret message")
+	encCtx := map[string]string{"tenant": "acme", "purpose": "billing"}
+
+	ciphertext, err := k.EncryptWithContext(ctx, plaintext, encCtx)
+	if err != nil {
+		t.Fatalf("EncryptWithContext: %v", err)
+	}
+	got, err := k.DecryptWithContext(ctx, ciphertext, encCtx)
+	if err != nil {
+		t.Fatalf("DecryptWithContext: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptWithContextMismatch(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	ciphertext, err := k.EncryptWithContext(ctx, []byte("plaintext"), map[string]string{"tenant": "acme"})
+	if err != nil {
+		t.Fatalf("EncryptWithContext: %v", err)
+	}
+	if _, err := k.DecryptWithContext(ctx, ciphertext, map[string]string{"tenant": "other"}); err == nil {
+		t.Fatal("DecryptWithContext with mismatched context: got nil error, want error")
+	}
+	if _, err := k.DecryptWithContext(ctx, ciphertext, nil); err == nil {
+		t.Fatal("DecryptWithContext with missing context: got nil error, want error")
+	}
+}
+
+func TestDecryptWithContextTamperedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	encCtx := map[string]string{"tenant": "acme"}
+	ciphertext, err := k.EncryptWithContext(ctx, []byte("plaintext"), encCtx)
+	if err != nil {
+		t.Fatalf("EncryptWithContext: %v", err)
+	}
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)-1] ^= 0xFF
+	if _, err := k.DecryptWithContext(ctx, tampered, encCtx); err == nil {
+		t.Fatal("DecryptWithContext of tampered ciphertext: got nil error, want error")
+	}
+}
+
+func TestDecryptWithContextTruncated(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	encCtx := map[string]string{"tenant": "acme"}
+	ciphertext, err := k.EncryptWithContext(ctx, []byte("plaintext"), encCtx)
+	if err != nil {
+		t.Fatalf("EncryptWithContext: %v", err)
+	}
+	for _, n := range []int{0, 1, 5, len(ciphertext) - 1} {
+		if _, err := k.DecryptWithContext(ctx, ciphertext[:n], encCtx); err == nil {
+			t.Fatalf("DecryptWithContext of ciphertext truncated to %d bytes: got nil error, want error", n)
+		}
+	}
+}
+
+func TestDecryptWithContextUnsupportedVersion(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	encCtx := map[string]string{"tenant": "acme"}
+	ciphertext, err := k.EncryptWithContext(ctx, []byte("plaintext"), encCtx)
+	if err != nil {
+		t.Fatalf("EncryptWithContext: %v", err)
+	}
+	ciphertext[0] = contextFrameVersion + 1
+	if _, err := k.DecryptWithContext(ctx, ciphertext, encCtx); err == nil {
+		t.Fatal("DecryptWithContext with unsupported version: got nil error, want error")
+	}
+}
+
+func TestDecryptWithContextRejectsOversizedFieldLength(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+
+	// A crafted frame whose wrapped-MAC-key length prefix is oversized must
+	// be rejected outright, not cause takeLenPrefixed to wrap around a
+	// 32-bit int and bypass its bounds check.
+	frame := make([]byte, 0, 5)
+	frame = append(frame, contextFrameVersion)
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], maxContextFrameFieldLen+1)
+	frame = append(frame, lenBuf[:]...)
+
+	if _, err := k.DecryptWithContext(ctx, frame, map[string]string{"tenant": "acme"}); err == nil {
+		t.Fatal("DecryptWithContext with oversized field length: got nil error, want error")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package driver defines interfaces to be implemented by secrets providers
+// and used by the secrets package.
+package driver // import "gocloud.dev/secrets/driver"
+
+import (
+	"context"
+	"crypto"
+
+	"gocloud.dev/gcerrors"
+)
+
+// Keeper does encryption and decryption for the secrets package. Particular
+// implementations, called providers, are in subpackages of secrets, e.g.,
+// gocloud.dev/secrets/localsecrets.
+type Keeper interface {
+	// Encrypt encrypts the plaintext and returns the cipher message.
+	Encrypt(ctx context.Context, plaintext []byte) (ciphertext []byte, err error)
+
+	// Decrypt decrypts the ciphertext and returns the plaintext.
+	Decrypt(ctx context.Context, ciphertext []byte) (plaintext []byte, err error)
+
+	// ErrorAs allows providers to expose provider-specific types for
+	// returned error. See Keeper.ErrorAs for more details.
+	ErrorAs(err error, i interface{}) bool
+
+	// ErrorCode should return a code that describes the error, which was
+	// returned by one of the other methods in this interface.
+	ErrorCode(error) gcerrors.ErrorCode
+
+	// Close cleans up any resources used by the Keeper.
+	Close() error
+}
+
+// Signer signs and verifies digests using an asymmetric key, for the
+// secrets package's Signer type. Particular implementations, called
+// providers, are in subpackages of secrets.
+type Signer interface {
+	// Sign signs digest, which is assumed to already be hashed with the
+	// algorithm returned by HashFunc, and returns the signature.
+	Sign(ctx context.Context, digest []byte) (signature []byte, err error)
+
+	// Verify reports whether signature is a valid signature of digest.
+	Verify(ctx context.Context, digest, signature []byte) (bool, error)
+
+	// HashFunc returns the hash algorithm that callers must use to produce
+	// digest before calling Sign or Verify, e.g. crypto.SHA256.
+	HashFunc() crypto.Hash
+
+	// ErrorAs allows providers to expose provider-specific types for
+	// returned error. See Signer.ErrorAs for more details.
+	ErrorAs(err error, i interface{}) bool
+
+	// ErrorCode should return a code that describes the error, which was
+	// returned by one of the other methods in this interface.
+	ErrorCode(error) gcerrors.ErrorCode
+
+	// Close cleans up any resources used by the Signer.
+	Close() error
+}
@@ -0,0 +1,233 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// envelopeVersion identifies the layout of the byte stream produced by
+// EncryptEnvelope, so that future changes to the format can be detected by
+// DecryptEnvelope.
+const envelopeVersion = 1
+
+// dekSize is the size in bytes of the randomly generated data encryption key
+// used for envelope encryption. AES-256 is used for the local AEAD step.
+const dekSize = 32
+
+// EncryptEnvelope encrypts plaintext using envelope encryption: it generates
+// a random data encryption key (DEK), seals plaintext locally with that DEK
+// using AES-GCM, and wraps the DEK by calling Encrypt on the underlying
+// driver. The returned envelope is self-describing and can be decrypted with
+// DecryptEnvelope.
+//
+// Envelope encryption lets a single KMS key (the "KEK") protect an unlimited
+// number of messages, each with its own DEK, without ever sending plaintext
+// to the KMS. It also means that key rotation (see KeyRotator) only needs to
+// re-wrap the small DEK, not re-encrypt the plaintext.
+//
+// aad, if non-nil, is additional authenticated data that is bound to the
+// envelope but not stored in it; the same aad must be passed to
+// DecryptEnvelope or decryption will fail.
+func (k *Keeper) EncryptEnvelope(ctx context.Context, plaintext, aad []byte) (envelope []byte, err error) {
+	ctx = k.tracer.Start(ctx, "EncryptEnvelope")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	dek, err := generateDEK()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("secrets: generating envelope nonce: %v", err)
+	}
+	wrappedDEK, err := k.Encrypt(ctx, dek)
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, nonce, plaintext, aad)
+
+	env := make([]byte, 0, 1+4+len(wrappedDEK)+len(nonce)+len(sealed))
+	env = append(env, envelopeVersion)
+	env = appendLenPrefixed(env, wrappedDEK)
+	env = append(env, nonce...)
+	env = append(env, sealed...)
+	return env, nil
+}
+
+// DecryptEnvelope decrypts an envelope produced by EncryptEnvelope: it
+// unwraps the DEK by calling Decrypt on the underlying driver, then uses it
+// to open the locally sealed ciphertext. aad must match the value passed to
+// EncryptEnvelope.
+func (k *Keeper) DecryptEnvelope(ctx context.Context, envelope, aad []byte) (plaintext []byte, err error) {
+	ctx = k.tracer.Start(ctx, "DecryptEnvelope")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	wrappedDEK, nonceAndSealed, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := k.Decrypt(ctx, wrappedDEK)
+	if err != nil {
+		return nil, err
+	}
+	return openWithDEK(dek, nonceAndSealed, aad)
+}
+
+// openWithDEK opens the nonce+ciphertext portion of an envelope using an
+// already-unwrapped DEK. It is shared by Keeper.DecryptEnvelope and
+// CachedKeeper, which unwraps the DEK itself (possibly from its cache)
+// before calling this.
+func openWithDEK(dek, nonceAndSealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonceAndSealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("secrets: envelope too short")
+	}
+	nonce, sealed := nonceAndSealed[:aead.NonceSize()], nonceAndSealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: decrypting envelope: %v", err)
+	}
+	return plaintext, nil
+}
+
+// parseEnvelope splits an envelope produced by EncryptEnvelope into its
+// wrapped DEK and the remaining nonce+ciphertext bytes.
+func parseEnvelope(envelope []byte) (wrappedDEK, rest []byte, err error) {
+	if len(envelope) < 1 {
+		return nil, nil, fmt.Errorf("secrets: empty envelope")
+	}
+	if v := envelope[0]; v != envelopeVersion {
+		return nil, nil, fmt.Errorf("secrets: unsupported envelope version %d", v)
+	}
+	envelope = envelope[1:]
+	if len(envelope) < 4 {
+		return nil, nil, fmt.Errorf("secrets: truncated envelope")
+	}
+	n := binary.BigEndian.Uint32(envelope)
+	envelope = envelope[4:]
+	if uint32(len(envelope)) < n {
+		return nil, nil, fmt.Errorf("secrets: truncated envelope")
+	}
+	return envelope[:n], envelope[n:], nil
+}
+
+// appendLenPrefixed appends b to dst preceded by its length as a 4-byte
+// big-endian unsigned integer.
+func appendLenPrefixed(dst, b []byte) []byte {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	dst = append(dst, lenBuf[:]...)
+	return append(dst, b...)
+}
+
+// generateDEK returns a fresh random data encryption key.
+func generateDEK() ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("secrets: generating data encryption key: %v", err)
+	}
+	return dek, nil
+}
+
+// newAEAD builds the AES-GCM AEAD used to seal plaintext locally under a DEK.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: building cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyRotator provides envelope encryption across multiple key-encryption
+// keys (KEKs), so that applications can rotate to a new KEK without having
+// to re-read and re-encrypt existing plaintext. It always encrypts with the
+// active Keeper, but can decrypt envelopes wrapped by any of the Keepers it
+// was constructed with.
+type KeyRotator struct {
+	active *Keeper
+	all    []*Keeper
+}
+
+// NewKeyRotator returns a KeyRotator that encrypts with active and can
+// decrypt envelopes wrapped by active or any of olderKeepers. olderKeepers
+// should be ordered most-recently-active first; it is used only as a hint
+// for which Keeper to try first when decrypting.
+func NewKeyRotator(active *Keeper, olderKeepers ...*Keeper) *KeyRotator {
+	all := make([]*Keeper, 0, len(olderKeepers)+1)
+	all = append(all, active)
+	all = append(all, olderKeepers...)
+	return &KeyRotator{active: active, all: all}
+}
+
+// EncryptEnvelope encrypts plaintext with the active Keeper.
+func (r *KeyRotator) EncryptEnvelope(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	return r.active.EncryptEnvelope(ctx, plaintext, aad)
+}
+
+// DecryptEnvelope decrypts envelope, trying each known Keeper in turn until
+// one decrypts it successfully. If none does, it returns the error from the
+// last Keeper tried.
+func (r *KeyRotator) DecryptEnvelope(ctx context.Context, envelope, aad []byte) ([]byte, error) {
+	var lastErr error
+	for _, k := range r.all {
+		plaintext, err := k.DecryptEnvelope(ctx, envelope, aad)
+		if err == nil {
+			return plaintext, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("secrets: no keepers configured")
+	}
+	return nil, lastErr
+}
+
+// Rewrap decrypts envelope with whichever known Keeper produced it, then
+// re-encrypts the plaintext with the active Keeper. Applications can use it
+// to migrate ciphertexts off a KEK that is being retired, without the
+// plaintext ever leaving process memory.
+func (r *KeyRotator) Rewrap(ctx context.Context, envelope, aad []byte) ([]byte, error) {
+	plaintext, err := r.DecryptEnvelope(ctx, envelope, aad)
+	if err != nil {
+		return nil, err
+	}
+	return r.active.EncryptEnvelope(ctx, plaintext, aad)
+}
+
+// Close closes the active Keeper and all older Keepers r was constructed
+// with. It closes all of them even if one fails, and returns the first
+// error encountered, if any.
+func (r *KeyRotator) Close() error {
+	var firstErr error
+	for _, k := range r.all {
+		if err := k.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEncryptDecryptEnvelopeRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	plaintext := []byte("a secret message")
+	aad := []byte("tenant-123")
+
+	env, err := k.EncryptEnvelope(ctx, plaintext, aad)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	got, err := k.DecryptEnvelope(ctx, env, aad)
+	if err != nil {
+		t.Fatalf("DecryptEnvelope: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptEnvelopeWrongAAD(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	env, err := k.EncryptEnvelope(ctx, []byte("plaintext"), []byte("correct-aad"))
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	if _, err := k.DecryptEnvelope(ctx, env, []byte("wrong-aad")); err == nil {
+		t.Fatal("DecryptEnvelope with wrong aad: got nil error, want error")
+	}
+}
+
+func TestDecryptEnvelopeTamperedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	env, err := k.EncryptEnvelope(ctx, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	tampered := append([]byte(nil), env...)
+	tampered[len(tampered)-1] ^= 0xFF
+	if _, err := k.DecryptEnvelope(ctx, tampered, nil); err == nil {
+		t.Fatal("DecryptEnvelope of tampered envelope: got nil error, want error")
+	}
+}
+
+func TestDecryptEnvelopeTruncated(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	env, err := k.EncryptEnvelope(ctx, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	for _, n := range []int{0, 1, 5, len(env) - 1} {
+		if _, err := k.DecryptEnvelope(ctx, env[:n], nil); err == nil {
+			t.Fatalf("DecryptEnvelope of envelope truncated to %d bytes: got nil error, want error", n)
+		}
+	}
+}
+
+func TestDecryptEnvelopeUnsupportedVersion(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	env, err := k.EncryptEnvelope(ctx, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	env[0] = envelopeVersion + 1
+	if _, err := k.DecryptEnvelope(ctx, env, nil); err == nil {
+		t.Fatal("DecryptEnvelope with unsupported version: got nil error, want error")
+	}
+}
+
+func TestKeyRotator(t *testing.T) {
+	ctx := context.Background()
+	oldKeeper := newFakeKeeper(1)
+	newKeeper := newFakeKeeper(2)
+	plaintext := []byte("plaintext encrypted under the old KEK")
+
+	env, err := oldKeeper.EncryptEnvelope(ctx, plaintext, nil)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+
+	rotator := NewKeyRotator(newKeeper, oldKeeper)
+
+	got, err := rotator.DecryptEnvelope(ctx, env, nil)
+	if err != nil {
+		t.Fatalf("DecryptEnvelope via rotator: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+
+	rewrapped, err := rotator.Rewrap(ctx, env, nil)
+	if err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	// The rewrapped envelope should now only be decryptable by the active
+	// (new) Keeper, not the old one.
+	if _, err := oldKeeper.DecryptEnvelope(ctx, rewrapped, nil); err == nil {
+		t.Fatal("old Keeper decrypted a rewrapped envelope: want error")
+	}
+	got, err = newKeeper.DecryptEnvelope(ctx, rewrapped, nil)
+	if err != nil {
+		t.Fatalf("DecryptEnvelope of rewrapped envelope with new Keeper: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+}
+
+func TestKeyRotatorClose(t *testing.T) {
+	oldKeeper := newFakeKeeper(1)
+	newKeeper := newFakeKeeper(2)
+	rotator := NewKeyRotator(newKeeper, oldKeeper)
+
+	if err := rotator.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !oldKeeper.k.(*fakeKeeperDriver).closed {
+		t.Error("old Keeper was not closed")
+	}
+	if !newKeeper.k.(*fakeKeeperDriver).closed {
+		t.Error("new Keeper was not closed")
+	}
+}
@@ -23,6 +23,7 @@
 // package. For example:
 //
 //  keeper := localsecrets.NewKeeper(myKey)
+//  defer keeper.Close()
 //  encrypted, err := keeper.Encrypt(ctx.Background(), []byte("text"))
 //  ...
 //
@@ -41,6 +42,14 @@
 // This API collects OpenCensus traces and metrics for the following methods:
 //  - Encrypt
 //  - Decrypt
+//  - EncryptWithContext
+//  - DecryptWithContext
+//  - EncryptEnvelope
+//  - DecryptEnvelope
+//  - EncryptStream
+//  - DecryptStream
+//  - Sign
+//  - Verify
 // All trace and metric names begin with the package import path.
 // The traces add the method name.
 // For example, "gocloud.dev/secrets/Encrypt".
@@ -122,6 +131,14 @@ func (k *Keeper) Decrypt(ctx context.Context, ciphertext []byte) (plaintext []by
 	return b, nil
 }
 
+// Close releases any resources used for the Keeper.
+func (k *Keeper) Close() error {
+	if err := k.k.Close(); err != nil {
+		return wrapError(k, err)
+	}
+	return nil
+}
+
 // ErrorAs converts i to provider-specific error types when you want to directly
 // handle the raw error types returned by the provider. This means that you
 // will write some provider-specific code to handle the error, so use with care.
@@ -212,6 +229,10 @@ func DefaultURLMux() *URLMux {
 //
 // See the URLOpener documentation in provider-specific subpackages for more
 // details on supported scheme(s) and URL parameter(s).
+//
+// The caller must call Close on the returned Keeper when it is done using
+// it, e.g. via "defer keeper.Close()", to release resources such as client
+// connections held by the underlying provider.
 func OpenKeeper(ctx context.Context, urlstr string) (*Keeper, error) {
 	return defaultURLMux.OpenKeeper(ctx, urlstr)
 }
@@ -0,0 +1,28 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import "testing"
+
+func TestKeeperCloseError(t *testing.T) {
+	driver := &fakeKeeperDriver{id: 1, key: 2, closeErr: errFakeKeeperClose}
+	k := NewKeeper(driver)
+	if err := k.Close(); err == nil {
+		t.Fatal("Close with a failing driver: got nil error, want error")
+	}
+	if !driver.closed {
+		t.Error("Close did not propagate to the driver")
+	}
+}
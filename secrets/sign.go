@@ -0,0 +1,187 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/url"
+
+	"gocloud.dev/internal/gcerr"
+	"gocloud.dev/internal/oc"
+	"gocloud.dev/secrets/driver"
+)
+
+// Signer signs and verifies digests using an asymmetric key. To create a
+// Signer, use constructors found in provider-specific subpackages.
+type Signer struct {
+	s      driver.Signer
+	tracer *oc.Tracer
+}
+
+// NewSigner is intended for use by provider implementations.
+var NewSigner = newSigner
+
+// newSigner creates a Signer.
+func newSigner(s driver.Signer) *Signer {
+	return &Signer{
+		s: s,
+		tracer: &oc.Tracer{
+			Package:        pkgName,
+			Provider:       oc.ProviderName(s),
+			LatencyMeasure: latencyMeasure,
+		},
+	}
+}
+
+// Sign signs digest, which the caller must have already hashed using the
+// algorithm returned by HashFunc, and returns the signature.
+func (s *Signer) Sign(ctx context.Context, digest []byte) (signature []byte, err error) {
+	ctx = s.tracer.Start(ctx, "Sign")
+	defer func() { s.tracer.End(ctx, err) }()
+
+	b, err := s.s.Sign(ctx, digest)
+	if err != nil {
+		return nil, wrapSignerError(s, err)
+	}
+	return b, nil
+}
+
+// Verify reports whether signature is a valid signature of digest.
+func (s *Signer) Verify(ctx context.Context, digest, signature []byte) (valid bool, err error) {
+	ctx = s.tracer.Start(ctx, "Verify")
+	defer func() { s.tracer.End(ctx, err) }()
+
+	ok, err := s.s.Verify(ctx, digest, signature)
+	if err != nil {
+		return false, wrapSignerError(s, err)
+	}
+	return ok, nil
+}
+
+// HashFunc returns the hash algorithm that callers must use to produce the
+// digest passed to Sign and Verify, e.g. crypto.SHA256.
+func (s *Signer) HashFunc() crypto.Hash {
+	return s.s.HashFunc()
+}
+
+// Close releases any resources used for the Signer.
+func (s *Signer) Close() error {
+	if err := s.s.Close(); err != nil {
+		return wrapSignerError(s, err)
+	}
+	return nil
+}
+
+// ErrorAs converts i to provider-specific error types when you want to
+// directly handle the raw error types returned by the provider. This means
+// that you will write some provider-specific code to handle the error, so
+// use with care.
+//
+// See the documentation for the subpackage used to instantiate Signer to
+// see which error type(s) are supported.
+//
+// ErrorAs panics if i is nil or not a pointer.
+// ErrorAs returns false if err == nil.
+func (s *Signer) ErrorAs(err error, i interface{}) bool {
+	return gcerr.ErrorAs(err, i, s.s.ErrorAs)
+}
+
+func wrapSignerError(s *Signer, err error) error {
+	if gcerr.DoNotWrap(err) {
+		return err
+	}
+	return gcerr.New(s.s.ErrorCode(err), err, 2, "secrets")
+}
+
+// SignerURLOpener represents types that can open Signers based on a URL.
+// The opener must not modify the URL argument. OpenSignerURL must be safe
+// to call from multiple goroutines.
+//
+// This interface is generally implemented by types in driver packages.
+type SignerURLOpener interface {
+	OpenSignerURL(ctx context.Context, u *url.URL) (*Signer, error)
+}
+
+// SignerURLMux is a URL opener multiplexer for Signers. It matches the
+// scheme of the URLs against a set of registered schemes and calls the
+// opener that matches the URL's scheme.
+//
+// The zero value is a multiplexer with no registered schemes.
+type SignerURLMux struct {
+	schemes map[string]SignerURLOpener
+}
+
+// RegisterSigner registers the opener with the given scheme. If an opener
+// already exists for the scheme, RegisterSigner panics.
+func (mux *SignerURLMux) RegisterSigner(scheme string, opener SignerURLOpener) {
+	if mux.schemes == nil {
+		mux.schemes = make(map[string]SignerURLOpener)
+	} else if _, exists := mux.schemes[scheme]; exists {
+		panic(fmt.Errorf("scheme %q already registered on mux", scheme))
+	}
+	mux.schemes[scheme] = opener
+}
+
+// OpenSigner calls OpenSignerURL with the URL parsed from urlstr.
+// OpenSigner is safe to call from multiple goroutines.
+func (mux *SignerURLMux) OpenSigner(ctx context.Context, urlstr string) (*Signer, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return nil, fmt.Errorf("open signer: %v", err)
+	}
+	return mux.OpenSignerURL(ctx, u)
+}
+
+// OpenSignerURL dispatches the URL to the opener that is registered with
+// the URL's scheme. OpenSignerURL is safe to call from multiple goroutines.
+func (mux *SignerURLMux) OpenSignerURL(ctx context.Context, u *url.URL) (*Signer, error) {
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("open signer %q: no scheme in URL", u)
+	}
+	var opener SignerURLOpener
+	if mux != nil {
+		opener = mux.schemes[u.Scheme]
+	}
+	if opener == nil {
+		return nil, fmt.Errorf("open signer %q: no provider registered for %s", u, u.Scheme)
+	}
+	return opener.OpenSignerURL(ctx, u)
+}
+
+var defaultSignerURLMux = new(SignerURLMux)
+
+// DefaultSignerURLMux returns the SignerURLMux used by OpenSigner.
+//
+// Driver packages can use this to register their SignerURLOpener on the
+// mux.
+func DefaultSignerURLMux() *SignerURLMux {
+	return defaultSignerURLMux
+}
+
+// OpenSigner opens the Signer identified by the URL given. URL openers
+// must be registered in the DefaultSignerURLMux, which is typically done
+// in driver packages' initialization.
+//
+// See the URLOpener documentation in provider-specific subpackages for more
+// details on supported scheme(s) and URL parameter(s).
+//
+// The caller must call Close on the returned Signer when it is done using
+// it, e.g. via "defer signer.Close()", to release resources such as client
+// connections held by the underlying provider.
+func OpenSigner(ctx context.Context, urlstr string) (*Signer, error) {
+	return defaultSignerURLMux.OpenSigner(ctx, urlstr)
+}
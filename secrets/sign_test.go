@@ -0,0 +1,138 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"net/url"
+	"testing"
+)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeSigner(1)
+	defer s.Close()
+
+	if s.HashFunc() != crypto.SHA256 {
+		t.Fatalf("HashFunc: got %v, want %v", s.HashFunc(), crypto.SHA256)
+	}
+
+	digest := sha256.Sum256([]byte("a message to sign"))
+	signature, err := s.Sign(ctx, digest[:])
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	valid, err := s.Verify(ctx, digest[:], signature)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !valid {
+		t.Fatal("Verify of a freshly-made signature: got false, want true")
+	}
+}
+
+func TestVerifyRejectsWrongSignature(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeSigner(1)
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("a message to sign"))
+	signature, err := s.Sign(ctx, digest[:])
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	tampered := append([]byte(nil), signature...)
+	tampered[0] ^= 0xFF
+
+	valid, err := s.Verify(ctx, digest[:], tampered)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if valid {
+		t.Fatal("Verify of a tampered signature: got true, want false")
+	}
+}
+
+func TestSignerErrorAs(t *testing.T) {
+	if newFakeSigner(1).ErrorAs(nil, new(int)) {
+		t.Error("ErrorAs with a nil error: got true, want false")
+	}
+}
+
+func TestSignerClose(t *testing.T) {
+	driver := &fakeSignerDriver{key: 1}
+	s := NewSigner(driver)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !driver.closed {
+		t.Error("Close did not propagate to the driver")
+	}
+}
+
+func TestSignerCloseError(t *testing.T) {
+	driver := &fakeSignerDriver{key: 1, closeErr: errFakeSignerClose}
+	s := NewSigner(driver)
+	if err := s.Close(); err == nil {
+		t.Fatal("Close with a failing driver: got nil error, want error")
+	}
+}
+
+type fakeSignerURLOpener struct {
+	key byte
+}
+
+func (o *fakeSignerURLOpener) OpenSignerURL(ctx context.Context, u *url.URL) (*Signer, error) {
+	return newFakeSigner(o.key), nil
+}
+
+func TestSignerURLMux(t *testing.T) {
+	ctx := context.Background()
+	mux := new(SignerURLMux)
+	mux.RegisterSigner("fake", &fakeSignerURLOpener{key: 1})
+
+	s, err := mux.OpenSigner(ctx, "fake://mykey")
+	if err != nil {
+		t.Fatalf("OpenSigner: %v", err)
+	}
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("a message to sign"))
+	signature, err := s.Sign(ctx, digest[:])
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if valid, err := s.Verify(ctx, digest[:], signature); err != nil || !valid {
+		t.Fatalf("Verify: got (%v, %v), want (true, nil)", valid, err)
+	}
+
+	if _, err := mux.OpenSigner(ctx, "unregistered://mykey"); err == nil {
+		t.Fatal("OpenSigner with an unregistered scheme: got nil error, want error")
+	}
+}
+
+func TestSignerURLMuxDuplicateSchemePanics(t *testing.T) {
+	mux := new(SignerURLMux)
+	mux.RegisterSigner("fake", &fakeSignerURLOpener{key: 1})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterSigner with a duplicate scheme: want panic, got none")
+		}
+	}()
+	mux.RegisterSigner("fake", &fakeSignerURLOpener{key: 2})
+}
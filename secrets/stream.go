@@ -0,0 +1,265 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// streamVersion identifies the layout of the header written by
+// EncryptStream, so that future changes to the format can be detected by
+// DecryptStream.
+const streamVersion = 1
+
+// streamChunkSize is the maximum number of plaintext bytes sealed into a
+// single segment. Segments are sealed locally with the DEK and are never
+// sent to the KMS, so single-call plaintext limits of KMS providers (for
+// example 64 KiB for GCP KMS, 4 KiB for AWS KMS asymmetric keys) apply only
+// to the DEK, which is wrapped once per stream, not to this size.
+const streamChunkSize = 64 * 1024
+
+// maxWrappedDEKLen bounds the wrapped-DEK length read from an untrusted
+// stream header. KMS-wrapped keys are at most a few KiB; anything larger
+// indicates a truncated or malicious header and must be rejected before
+// allocating a buffer for it.
+const maxWrappedDEKLen = 4 * 1024
+
+// maxSealedSegmentLen bounds the sealed-segment length read from an
+// untrusted stream. It must be able to hold a full plaintext chunk plus the
+// AEAD overhead; anything larger indicates a truncated or malicious stream.
+const maxSealedSegmentLen = streamChunkSize + 64
+
+// EncryptStream returns a Writer that envelope-encrypts everything written
+// to it and writes the resulting ciphertext to dst. It generates a single
+// DEK, wraps it once via the underlying driver, and then seals the stream in
+// fixed-size segments so that plaintext of any length can be encrypted
+// without exceeding a KMS provider's per-call size limit. The caller must
+// call Close on the returned Writer to flush the final segment.
+func (k *Keeper) EncryptStream(ctx context.Context, dst io.Writer) (w io.WriteCloser, err error) {
+	ctx = k.tracer.Start(ctx, "EncryptStream")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	dek, err := generateDEK()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	wrappedDEK, err := k.Encrypt(ctx, dek)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeStreamHeader(dst, wrappedDEK); err != nil {
+		return nil, err
+	}
+	return &streamWriter{dst: dst, aead: aead}, nil
+}
+
+// DecryptStream returns a Reader that reads ciphertext produced by
+// EncryptStream from src and yields the decrypted plaintext. It returns an
+// error if src is truncated: a stream that ends without its final segment
+// is never silently treated as complete.
+func (k *Keeper) DecryptStream(ctx context.Context, src io.Reader) (r io.ReadCloser, err error) {
+	ctx = k.tracer.Start(ctx, "DecryptStream")
+	defer func() { k.tracer.End(ctx, err) }()
+
+	wrappedDEK, err := readStreamHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := k.Decrypt(ctx, wrappedDEK)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{src: src, aead: aead}, nil
+}
+
+func writeStreamHeader(dst io.Writer, wrappedDEK []byte) error {
+	header := make([]byte, 0, 1+4+len(wrappedDEK))
+	header = append(header, streamVersion)
+	header = appendLenPrefixed(header, wrappedDEK)
+	if _, err := dst.Write(header); err != nil {
+		return fmt.Errorf("secrets: writing stream header: %v", err)
+	}
+	return nil
+}
+
+func readStreamHeader(src io.Reader) (wrappedDEK []byte, err error) {
+	var versionAndLen [5]byte
+	if _, err := io.ReadFull(src, versionAndLen[:]); err != nil {
+		return nil, fmt.Errorf("secrets: reading stream header: %v", err)
+	}
+	if v := versionAndLen[0]; v != streamVersion {
+		return nil, fmt.Errorf("secrets: unsupported stream version %d", v)
+	}
+	n := binary.BigEndian.Uint32(versionAndLen[1:])
+	if n > maxWrappedDEKLen {
+		return nil, fmt.Errorf("secrets: wrapped DEK length %d exceeds maximum of %d", n, maxWrappedDEKLen)
+	}
+	wrappedDEK = make([]byte, n)
+	if _, err := io.ReadFull(src, wrappedDEK); err != nil {
+		return nil, fmt.Errorf("secrets: reading stream header: %v", err)
+	}
+	return wrappedDEK, nil
+}
+
+// streamNonce derives the AEAD nonce for segment counter from a monotonic
+// per-stream counter, so that no two segments of the same stream ever reuse
+// a nonce.
+func streamNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// streamWriter implements io.WriteCloser for EncryptStream.
+type streamWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	closed  bool
+	err     error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= streamChunkSize {
+		if err := w.sealSegment(w.buf[:streamChunkSize], false); err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.buf = w.buf[streamChunkSize:]
+	}
+	return n, nil
+}
+
+func (w *streamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.sealSegment(w.buf, true); err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = nil
+	return nil
+}
+
+func (w *streamWriter) sealSegment(plaintext []byte, final bool) error {
+	aad := segmentAAD(final)
+	sealed := w.aead.Seal(nil, streamNonce(w.counter), plaintext, aad)
+	w.counter++
+
+	frame := make([]byte, 0, 1+4+len(sealed))
+	frame = append(frame, aad[0])
+	frame = appendLenPrefixed(frame, sealed)
+	if _, err := w.dst.Write(frame); err != nil {
+		return fmt.Errorf("secrets: writing stream segment: %v", err)
+	}
+	return nil
+}
+
+// streamReader implements io.ReadCloser for DecryptStream.
+type streamReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	done    bool
+	err     error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readSegment(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	return nil
+}
+
+func (r *streamReader) readSegment() error {
+	var finalByte [1]byte
+	if _, err := io.ReadFull(r.src, finalByte[:]); err != nil {
+		return fmt.Errorf("secrets: truncated stream: %v", err)
+	}
+	final := finalByte[0] == 1
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r.src, lenBuf[:]); err != nil {
+		return fmt.Errorf("secrets: truncated stream: %v", err)
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n > maxSealedSegmentLen {
+		return fmt.Errorf("secrets: stream segment length %d exceeds maximum of %d", n, maxSealedSegmentLen)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return fmt.Errorf("secrets: truncated stream: %v", err)
+	}
+
+	plaintext, err := r.aead.Open(nil, streamNonce(r.counter), sealed, segmentAAD(final))
+	if err != nil {
+		return fmt.Errorf("secrets: decrypting stream segment: %v", err)
+	}
+	r.counter++
+	r.buf = plaintext
+	if final {
+		r.done = true
+	}
+	return nil
+}
+
+// segmentAAD returns the one-byte additional authenticated data that binds
+// the "is this the final segment" flag into the segment's AEAD tag, so a
+// truncated stream cannot be mistaken for a complete one.
+func segmentAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func encryptStreamToBytes(t *testing.T, k *Keeper, plaintext []byte) []byte {
+	t.Helper()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w, err := k.EncryptStream(ctx, &buf)
+	if err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptDecryptStreamRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	// Exercise multiple segments, including a partial final one.
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), streamChunkSize/8+1)
+
+	ciphertext := encryptStreamToBytes(t, k, plaintext)
+
+	r, err := k.DecryptStream(ctx, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("DecryptStream: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decrypted stream: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("got %d bytes, want %d bytes; content mismatch", len(got), len(plaintext))
+	}
+}
+
+func TestEncryptDecryptStreamEmpty(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	ciphertext := encryptStreamToBytes(t, k, nil)
+
+	r, err := k.DecryptStream(ctx, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("DecryptStream: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decrypted stream: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got %q, want empty", got)
+	}
+}
+
+func TestDecryptStreamTruncated(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	ciphertext := encryptStreamToBytes(t, k, []byte("some plaintext"))
+
+	// Cutting off the end of the stream, here the last byte of its final
+	// segment, should surface as an error, never a silently short read.
+	truncated := ciphertext[:len(ciphertext)-1]
+	r, err := k.DecryptStream(ctx, bytes.NewReader(truncated))
+	if err != nil {
+		// Failing while reading the header is also an acceptable outcome.
+		return
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("reading truncated stream: got nil error, want error")
+	}
+}
+
+func TestDecryptStreamTamperedSegment(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	ciphertext := encryptStreamToBytes(t, k, []byte("some plaintext"))
+	ciphertext[len(ciphertext)-1] ^= 0xFF
+
+	r, err := k.DecryptStream(ctx, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("DecryptStream: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("reading tampered stream: got nil error, want error")
+	}
+}
+
+func TestDecryptStreamRejectsOversizedWrappedDEKLength(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	var header [5]byte
+	header[0] = streamVersion
+	binary.BigEndian.PutUint32(header[1:], maxWrappedDEKLen+1)
+
+	if _, err := k.DecryptStream(ctx, bytes.NewReader(header[:])); err == nil {
+		t.Fatal("DecryptStream with oversized wrapped DEK length: got nil error, want error")
+	}
+}
+
+func TestDecryptStreamRejectsOversizedSegmentLength(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeeper(1)
+	ciphertext := encryptStreamToBytes(t, k, []byte("some plaintext"))
+
+	// Keep the real header, with its valid wrapped DEK, and replace the
+	// first segment's length prefix with an oversized value.
+	wrappedDEKLen := binary.BigEndian.Uint32(ciphertext[1:5])
+	segmentStart := 5 + int(wrappedDEKLen)
+
+	crafted := append([]byte(nil), ciphertext[:segmentStart]...)
+	crafted = append(crafted, 0) // not final
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], maxSealedSegmentLen+1)
+	crafted = append(crafted, lenBuf[:]...)
+
+	r, err := k.DecryptStream(ctx, bytes.NewReader(crafted))
+	if err != nil {
+		t.Fatalf("DecryptStream: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("reading stream with oversized segment length: got nil error, want error")
+	}
+}
+
+var _ io.Reader = (*streamReader)(nil)